@@ -8,143 +8,303 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-colorable"
+	"golang.org/x/term"
 )
 
-var colors = []*color.Color{
-	color.New(color.FgHiRed),
-	color.New(color.FgHiGreen),
-	color.New(color.FgHiYellow),
-	color.New(color.FgHiBlue),
-	color.New(color.FgHiMagenta),
-	color.New(color.FgHiCyan),
-	color.New(color.FgHiWhite),
-	color.New(color.FgRed, color.ReverseVideo),
-	color.New(color.FgGreen, color.ReverseVideo),
-	color.New(color.FgYellow, color.ReverseVideo),
-	color.New(color.FgBlue, color.ReverseVideo),
-	color.New(color.FgMagenta, color.ReverseVideo),
-	color.New(color.FgCyan, color.ReverseVideo),
-	color.New(color.FgWhite, color.ReverseVideo),
-}
-
 func main() {
 	fail := flag.Bool("fail", false, "terminate if any task fails with error")
+	format := flag.String("format", "text", "output format: text or json")
+	noColor := flag.Bool("no-color", false, "disable ANSI color output")
+	grace := flag.Duration("grace-period", 10*time.Second, "time to wait for tasks to exit after SIGINT/SIGTERM before sending SIGKILL")
+	paletteSpec := flag.String("palette", "", "comma-separated mgutz/ansi-style color specs (e.g. red+b,green,208+b,white+b:red) overriding the default palette")
+	reportTarget := flag.String("report", "", "URL to POST JSON report events to on task start/end, or a |command to pipe them to")
+	reportLines := flag.Bool("report-lines", false, "also send a report event for every output line (requires -report)")
 	flag.Parse()
-	tasks := flag.Args()
+	tasks := parseTasks(flag.Args())
 
-	if len(tasks) > len(colors) {
-		log.Fatal("Too many tasks!")
+	palette := defaultPalette
+	if *paletteSpec != "" {
+		var err error
+		palette, err = parsePalette(*paletteSpec)
+		if err != nil {
+			log.Fatalf("-palette: %v", err)
+		}
 	}
 
-	// safeWriter protects stdout and stderr for concurrent access
-	stdout := &safeWriter{W: os.Stdout}
-	stderr := &safeWriter{W: os.Stderr}
+	if *noColor || os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		color.NoColor = true
+	}
+
+	// safeWriter protects stdout and stderr for concurrent access. They
+	// share a single mutex: on Windows both are backed by the same
+	// console handle, so interleaved writes from different tasks could
+	// otherwise land mid-escape-sequence and garble the console's color
+	// state. colorable translates the ANSI escapes fatih/color emits
+	// into SetConsoleTextAttribute calls on Windows; elsewhere it is a
+	// passthrough to the file.
+	mu := &sync.Mutex{}
+	stdout := &safeWriter{W: colorable.NewColorableStdout(), mu: mu}
+	stderr := &safeWriter{W: colorable.NewColorableStderr(), mu: mu}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(tasks))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	for i, command := range tasks {
-		colorOut := colorize(stdout, i)
-		colorErr := colorize(stderr, i)
-		fmt.Fprintf(colorErr, "%d> Running: %s\n", i, command)
-
-		go func(i int, command string) {
-			if err := runCommand(ctx, command, colorOut, colorErr); err != nil {
-				fmt.Fprintf(stderr, "%d> command failed with %v\n", i, err)
-				if *fail { // terminate other tasks on failure
-					cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sigCh; ok {
+			cancel()
+		}
+	}()
+
+	// rep's HTTP/pipe delivery is tied to ctx so that a stalled -report
+	// target can't outlive shutdown: canceling ctx aborts any in-flight
+	// report request just like it does a running task.
+	var rep *reporter
+	if *reportTarget != "" {
+		rep = newReporter(ctx, *reportTarget, *reportLines)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(tasks))
+	var exitMu sync.Mutex
+	exitCode := 0
+	failed := false
+	for _, t := range tasks {
+		t := t // capture per iteration for the closures below
+		c := paletteColorFor(palette, t.Name)
+		colorOut := newFormatter(*format, stdout, t.Name, t.Command, "stdout", c)
+		colorErr := newFormatter(*format, stderr, t.Name, t.Command, "stderr", c)
+		var taskOut, taskErr io.WriteCloser = colorOut, colorErr
+		if rep != nil {
+			// Tap raw command output before it reaches colorOut/colorErr, so
+			// the reporter sees plain lines instead of colored or NDJSON text.
+			taskOut = newReportTap(colorOut, func(line []byte) { rep.line(t.Name, "stdout", line) })
+			taskErr = newReportTap(colorErr, func(line []byte) { rep.line(t.Name, "stderr", line) })
+		}
+		if *format != "json" {
+			// In JSON mode this text-mode banner has no place in the
+			// structured per-line output: it would show up as an
+			// otherwise-NDJSON stream's one line whose "line" field embeds
+			// a "name> Running: ..." prefix meant for the text formatter.
+			fmt.Fprintf(colorErr, "%s> Running: %s\n", t.Name, t.Command)
+		}
+
+		go func(t task) {
+			code, err := runCommand(ctx, t.Command, taskOut, taskErr, *grace, rep, t.Name)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s> command failed with %v\n", t.Name, err)
+			}
+			exitMu.Lock()
+			if *fail {
+				if code != 0 && !failed {
+					exitCode, failed = code, true
 				}
+			} else if code > exitCode {
+				exitCode = code
+			}
+			exitMu.Unlock()
+			if *fail && code != 0 { // terminate other tasks on failure
+				cancel()
 			}
+			// runCommand has already closed taskOut/taskErr (which alias
+			// colorOut/colorErr when -report is unset) to flush any
+			// trailing partial line before its "end" report; closing
+			// colorOut/colorErr here too is safe since lineSplitter.Close
+			// is idempotent, and required when -report wraps them in a
+			// separate reportTap that runCommand closed instead.
 			colorOut.Close()
 			colorErr.Close()
 			wg.Done()
-		}(i, command)
+		}(t)
 	}
 	wg.Wait()
+	rep.close()
+	signal.Stop(sigCh)
+	close(sigCh)
+	os.Exit(exitCode)
 }
 
-func runCommand(ctx context.Context, command string, stdout, stderr io.Writer) error {
+// runCommand runs command to completion and returns its resolved exit
+// code alongside any error from starting or waiting for it. If ctx is
+// canceled before the command exits, it is sent SIGTERM; if it has not
+// exited after grace, it is sent SIGKILL. If rep is non-nil, it is sent a
+// "start" event once command has been started and an "end" event once it
+// has exited or failed to start. stdout and stderr are closed before the
+// "end" event is sent, so any trailing partial line reaches rep's tail
+// buffer in time to be included in that event.
+func runCommand(ctx context.Context, command string, stdout, stderr io.WriteCloser, grace time.Duration, rep *reporter, task string) (exitCode int, err error) {
 	commandLine := append(shellCommand(), command)
 	cmd := exec.CommandContext(ctx, commandLine[0], commandLine[1:]...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	if err := cmd.Start(); err != nil {
-		return err
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = grace
+	start := time.Now()
+	if err = cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		rep.end(task, 127, time.Since(start))
+		return 127, err
+	}
+	rep.start(task, command, cmd.Process.Pid)
+	err = cmd.Wait()
+	exitCode = resolveExitCode(err)
+	stdout.Close()
+	stderr.Close()
+	rep.end(task, exitCode, time.Since(start))
+	return exitCode, err
+}
+
+// resolveExitCode maps the error returned by (*exec.Cmd).Wait to a shell
+// exit status: the child's own exit code, 128+signum if it died from a
+// signal, or 127 if it could not be started or waited for at all.
+func resolveExitCode(err error) int {
+	if err == nil {
+		return 0
 	}
-	if err := cmd.Wait(); err != nil {
-		return err
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ProcessState.ExitCode()
 	}
-	return nil
+	return 127
 }
 
 func shellCommand() []string {
 	return []string{"bash", "-c"}
 }
 
-func colorize(dst io.Writer, i int) io.WriteCloser {
-	return &colorizer{
-		W:      dst,
-		Prefix: fmt.Sprintf("%d> ", i),
-		Color:  colors[i],
+// lineFormatter writes complete lines from one task's output stream to an
+// underlying writer, buffering any trailing partial line until the next
+// Write or Close. colorizer and jsonFormatter are the two implementations.
+type lineFormatter interface {
+	io.WriteCloser
+}
+
+// newFormatter builds the lineFormatter for a task's stream ("stdout" or
+// "stderr") according to format, which is "json" or anything else for the
+// default ANSI-colored text formatter. name is the task's label, used as
+// the NDJSON task field or the text-mode line prefix.
+func newFormatter(format string, dst io.Writer, name, command, stream string, c *color.Color) lineFormatter {
+	if format == "json" {
+		return newJSONFormatter(dst, name, command, stream)
 	}
+	return newColorizer(dst, name+"> ", c)
 }
 
-type colorizer struct {
-	W      io.Writer
-	Prefix string
-	Color  *color.Color
+// lineSplitter buffers writes and calls emit once per complete line,
+// carrying over any trailing partial line across calls to Write.
+type lineSplitter struct {
+	emit func(line []byte) error
 
 	trailer []byte
 }
 
-func (c *colorizer) write(prev, line []byte) (err error) {
-	_, err = c.Color.Fprintf(c.W, "%s%s%s\n", c.Prefix, prev, line)
-	return
-}
-
-// Write writes the contents of p into W with color coding.
-// Each Stream is output with an unique color.
-// If p does not end with a newline, the trailing partial line
-// is buffered and will be output on next write or on Close.
-func (c *colorizer) Write(p []byte) (n int, err error) {
+// Write splits p into lines and calls emit for each complete line,
+// prepending any partial line left over from a previous Write.
+// If p does not end with a newline, the trailing partial line is
+// buffered and will be emitted on the next Write or on Close.
+func (l *lineSplitter) Write(p []byte) (n int, err error) {
 	n = len(p)
 	for {
 		pos := bytes.IndexByte(p, '\n')
 		if pos == -1 { // incomplete last line
-			c.trailer = append(c.trailer[:0], p...)
+			l.trailer = append(l.trailer, p...)
 			return
 		}
-		line := p[:pos]
-		if err := c.write(c.trailer, line); err != nil {
+		line := append(l.trailer, p[:pos]...)
+		if err := l.emit(line); err != nil {
 			return n, err
 		}
 		p = p[pos+1:]
-		c.trailer = nil
+		l.trailer = nil
 	}
 }
 
-// Close writes trailing data not terminated with a newline.
-func (c *colorizer) Close() error {
-	if len(c.trailer) > 0 {
-		return c.write(c.trailer, nil)
+// Close emits trailing data not terminated with a newline. It is
+// idempotent: a second Close (as happens when a caller's taskOut/taskErr
+// alias colorOut/colorErr) finds the trailer already cleared and is a
+// no-op, rather than re-emitting the same trailing line.
+func (l *lineSplitter) Close() error {
+	if len(l.trailer) == 0 {
+		return nil
 	}
-	return nil
+	trailer := l.trailer
+	l.trailer = nil
+	return l.emit(trailer)
 }
 
-type safeWriter struct {
-	W io.Writer
+// colorizer prefixes each line written to W with Prefix and colors it
+// with Color. Each Stream is output with a unique color.
+type colorizer struct {
+	*lineSplitter
+}
+
+func newColorizer(w io.Writer, prefix string, c *color.Color) *colorizer {
+	return &colorizer{lineSplitter: &lineSplitter{
+		emit: func(line []byte) error {
+			_, err := c.Fprintf(w, "%s%s\n", prefix, line)
+			return err
+		},
+	}}
+}
+
+// jsonLine is one line of NDJSON output emitted by jsonFormatter.
+type jsonLine struct {
+	Task   string    `json:"task"`
+	Cmd    string    `json:"cmd"`
+	Stream string    `json:"stream"`
+	TS     time.Time `json:"ts"`
+	Line   string    `json:"line"`
+}
 
-	mu sync.Mutex
+// jsonFormatter writes each line written to W as one NDJSON object
+// carrying the task's metadata, for consumption by log aggregators and
+// CI systems in place of ANSI-colored text.
+type jsonFormatter struct {
+	*lineSplitter
+}
+
+func newJSONFormatter(w io.Writer, task, cmd, stream string) *jsonFormatter {
+	enc := json.NewEncoder(w)
+	return &jsonFormatter{lineSplitter: &lineSplitter{
+		emit: func(line []byte) error {
+			return enc.Encode(jsonLine{
+				Task:   task,
+				Cmd:    cmd,
+				Stream: stream,
+				TS:     time.Now(),
+				Line:   string(line),
+			})
+		},
+	}}
+}
+
+// safeWriter protects W for concurrent access. mu may be shared with
+// other safeWriters backed by the same underlying handle.
+type safeWriter struct {
+	W  io.Writer
+	mu *sync.Mutex
 }
 
 func (s *safeWriter) Write(data []byte) (n int, err error) {
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestResolveExitCode(t *testing.T) {
+	if code := resolveExitCode(nil); code != 0 {
+		t.Errorf("resolveExitCode(nil) = %d, want 0", code)
+	}
+
+	commandLine := append(shellCommand(), "exit 3")
+	_, err := exec.Command(commandLine[0], commandLine[1:]...).CombinedOutput()
+	if code := resolveExitCode(err); code != 3 {
+		t.Errorf("resolveExitCode(exit 3) = %d, want 3", code)
+	}
+
+	commandLine = append(shellCommand(), "kill -TERM $$")
+	err = exec.Command(commandLine[0], commandLine[1:]...).Run()
+	if want := 128 + int(syscall.SIGTERM); resolveExitCode(err) != want {
+		t.Errorf("resolveExitCode(SIGTERM) = %d, want %d", resolveExitCode(err), want)
+	}
+
+	if code := resolveExitCode(errors.New("did not start")); code != 127 {
+		t.Errorf("resolveExitCode(generic error) = %d, want 127", code)
+	}
+}
+
+func TestLineSplitterCloseIsIdempotent(t *testing.T) {
+	var got []string
+	ls := &lineSplitter{emit: func(line []byte) error {
+		got = append(got, string(line))
+		return nil
+	}}
+	ls.Write([]byte("line1\nline2-no-"))
+	ls.Write([]byte("newline"))
+	if err := ls.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	want := []string{"line1", "line2-no-newline"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("emitted lines = %v, want %v", got, want)
+	}
+
+	// A second Close, as happens when a caller's taskOut/taskErr alias
+	// colorOut/colorErr, must not re-emit the trailing line.
+	if err := ls.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted lines after second Close = %v, want unchanged %v", got, want)
+	}
+}
+
+func TestJSONFormatterEncodesLines(t *testing.T) {
+	var buf bytes.Buffer
+	f := newJSONFormatter(&buf, "web", "./serve", "stdout")
+	io.WriteString(f, "hello\nworld")
+	f.Close()
+
+	dec := json.NewDecoder(&buf)
+	var lines []jsonLine
+	for dec.More() {
+		var l jsonLine
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 2 || lines[0].Line != "hello" || lines[1].Line != "world" {
+		t.Fatalf("lines = %+v", lines)
+	}
+	for _, l := range lines {
+		if l.Task != "web" || l.Cmd != "./serve" || l.Stream != "stdout" {
+			t.Errorf("line metadata = %+v, want task=web cmd=./serve stream=stdout", l)
+		}
+	}
+}
+
+// TestRunCommandDoesNotDuplicateTrailingLine reproduces the -report-unset
+// path, where main's taskOut/taskErr alias colorOut/colorErr and both get
+// Closed: runCommand closes them once to flush the trailing partial line
+// into the report tail (when -report is set) and main closes them again
+// unconditionally afterward.
+func TestRunCommandDoesNotDuplicateTrailingLine(t *testing.T) {
+	color.NoColor = true
+	var buf bytes.Buffer
+	c := color.New(color.FgWhite)
+	colorOut := newColorizer(&buf, "0> ", c)
+	colorErr := newColorizer(io.Discard, "0> ", c)
+	var taskOut, taskErr io.WriteCloser = colorOut, colorErr // mirrors main when -report is unset
+
+	code, err := runCommand(context.Background(), `printf 'hello-no-newline'`, taskOut, taskErr, time.Second, nil, "0")
+	if err != nil || code != 0 {
+		t.Fatalf("runCommand: code=%d err=%v", code, err)
+	}
+	colorOut.Close() // main always closes colorOut/colorErr, even when they alias taskOut/taskErr
+	colorErr.Close()
+
+	if got, want := buf.String(), "0> hello-no-newline\n"; got != want {
+		t.Errorf("stdout = %q, want %q (trailing partial line must appear exactly once)", got, want)
+	}
+}
+
+// TestRunCommandFlushesTrailingLineBeforeReportEnd reproduces the
+// -report path, where the reporter's "end" event must include the
+// command's last line even when it lacks a trailing newline.
+func TestRunCommandFlushesTrailingLineBeforeReportEnd(t *testing.T) {
+	color.NoColor = true
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "report.ndjson")
+	rep := newReporter(context.Background(), "|cat > "+outFile, false)
+
+	c := color.New(color.FgWhite)
+	colorOut := newColorizer(io.Discard, "0> ", c)
+	colorErr := newColorizer(io.Discard, "0> ", c)
+	taskOut := newReportTap(colorOut, func(line []byte) { rep.line("0", "stdout", line) })
+	taskErr := newReportTap(colorErr, func(line []byte) { rep.line("0", "stderr", line) })
+
+	code, err := runCommand(context.Background(), `printf 'line1\nline2-no-newline'`, taskOut, taskErr, time.Second, rep, "0")
+	if err != nil || code != 0 {
+		t.Fatalf("runCommand: code=%d err=%v", code, err)
+	}
+	colorOut.Close()
+	colorErr.Close()
+	rep.close()
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("reading report output: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	var last reportEvent
+	if err := json.Unmarshal(lines[len(lines)-1], &last); err != nil {
+		t.Fatalf("decoding report event %q: %v", lines[len(lines)-1], err)
+	}
+	if last.Event != "end" {
+		t.Fatalf("last event = %q, want %q", last.Event, "end")
+	}
+	want := []string{"line1", "line2-no-newline"}
+	if !reflect.DeepEqual(last.StdoutTail, want) {
+		t.Errorf("StdoutTail = %v, want %v (trailing line without a newline must still be reported)", last.StdoutTail, want)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// task is one command to run, labeled with the prefix used on its output.
+type task struct {
+	Name    string
+	Command string
+}
+
+// taskNameRE matches the label half of a "name=command" task spec. It
+// requires a lowercase-leading identifier so that the common shell idiom
+// of prefixing a command with an inline environment assignment, e.g.
+// "PORT=3000 ./serve" or "FOO=bar echo $FOO", is never mistaken for a
+// task name: env vars are conventionally upper-cased, task labels are not.
+var taskNameRE = regexp.MustCompile(`^[a-z][a-zA-Z0-9_.-]*$`)
+
+// parseTasks turns the command-line task arguments into tasks. An argument
+// of the form "name=command" uses name as the label, provided name looks
+// like a task label rather than a shell env-var assignment (see
+// taskNameRE); otherwise the task's position in args (as a string) is
+// used, preserving colorout's original numeric prefixes.
+func parseTasks(args []string) []task {
+	tasks := make([]task, len(args))
+	for i, arg := range args {
+		name, command, ok := strings.Cut(arg, "=")
+		if !ok || !taskNameRE.MatchString(name) {
+			name, command = strconv.Itoa(i), arg
+		}
+		tasks[i] = task{Name: name, Command: command}
+	}
+	return tasks
+}
+
+// defaultPalette is used when -palette is not given.
+var defaultPalette = []*color.Color{
+	color.New(color.FgHiRed),
+	color.New(color.FgHiGreen),
+	color.New(color.FgHiYellow),
+	color.New(color.FgHiBlue),
+	color.New(color.FgHiMagenta),
+	color.New(color.FgHiCyan),
+	color.New(color.FgHiWhite),
+	color.New(color.FgRed, color.ReverseVideo),
+	color.New(color.FgGreen, color.ReverseVideo),
+	color.New(color.FgYellow, color.ReverseVideo),
+	color.New(color.FgBlue, color.ReverseVideo),
+	color.New(color.FgMagenta, color.ReverseVideo),
+	color.New(color.FgCyan, color.ReverseVideo),
+	color.New(color.FgWhite, color.ReverseVideo),
+}
+
+// paletteColorFor picks a color for name from palette by hashing it, so a
+// given task name always gets the same color and any number of tasks can
+// share a palette shorter than the task list.
+func paletteColorFor(palette []*color.Color, name string) *color.Color {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+var fgColors = map[string]color.Attribute{
+	"black": color.FgBlack, "red": color.FgRed, "green": color.FgGreen,
+	"yellow": color.FgYellow, "blue": color.FgBlue, "magenta": color.FgMagenta,
+	"cyan": color.FgCyan, "white": color.FgWhite,
+}
+
+var fgHiColors = map[string]color.Attribute{
+	"black": color.FgHiBlack, "red": color.FgHiRed, "green": color.FgHiGreen,
+	"yellow": color.FgHiYellow, "blue": color.FgHiBlue, "magenta": color.FgHiMagenta,
+	"cyan": color.FgHiCyan, "white": color.FgHiWhite,
+}
+
+var bgColors = map[string]color.Attribute{
+	"black": color.BgBlack, "red": color.BgRed, "green": color.BgGreen,
+	"yellow": color.BgYellow, "blue": color.BgBlue, "magenta": color.BgMagenta,
+	"cyan": color.BgCyan, "white": color.BgWhite,
+}
+
+var bgHiColors = map[string]color.Attribute{
+	"black": color.BgHiBlack, "red": color.BgHiRed, "green": color.BgHiGreen,
+	"yellow": color.BgHiYellow, "blue": color.BgHiBlue, "magenta": color.BgHiMagenta,
+	"cyan": color.BgHiCyan, "white": color.BgHiWhite,
+}
+
+// basicFgByCode and basicBgByCode approximate an xterm-256 color code as
+// one of the 8 base ANSI colors, since fatih/color has no 256-color
+// support to render the real thing.
+var basicFgByCode = []color.Attribute{
+	color.FgBlack, color.FgRed, color.FgGreen, color.FgYellow,
+	color.FgBlue, color.FgMagenta, color.FgCyan, color.FgWhite,
+}
+
+var basicBgByCode = []color.Attribute{
+	color.BgBlack, color.BgRed, color.BgGreen, color.BgYellow,
+	color.BgBlue, color.BgMagenta, color.BgCyan, color.BgWhite,
+}
+
+// parsePalette parses a comma-separated list of mgutz/ansi-style color
+// specs, e.g. "red+b,green,208+b,white+b:red", into a palette usable by
+// paletteColorFor.
+func parsePalette(spec string) ([]*color.Color, error) {
+	specs := strings.Split(spec, ",")
+	palette := make([]*color.Color, len(specs))
+	for i, s := range specs {
+		c, err := parseColorSpec(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("palette entry %q: %w", s, err)
+		}
+		palette[i] = c
+	}
+	return palette, nil
+}
+
+// parseColorSpec parses one "fg[+mods][:bg[+mods]]" entry.
+func parseColorSpec(s string) (*color.Color, error) {
+	fg, bg, hasBg := strings.Cut(s, ":")
+	attrs, err := parseColorAttr(fg, fgColors, fgHiColors, basicFgByCode)
+	if err != nil {
+		return nil, err
+	}
+	if hasBg {
+		bgAttrs, err := parseColorAttr(bg, bgColors, bgHiColors, basicBgByCode)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, bgAttrs...)
+	}
+	return color.New(attrs...), nil
+}
+
+// parseColorAttr parses a single "name[+mods]" side of a color spec.
+// name is a color name from byName, a "+h" (high-intensity) variant from
+// hiByName, or a decimal xterm-256 code approximated via byCode. The "+b"
+// modifier adds Bold and "+u" adds Underline, as in mgutz/ansi.
+func parseColorAttr(s string, byName, hiByName map[string]color.Attribute, byCode []color.Attribute) ([]color.Attribute, error) {
+	name, mods, _ := strings.Cut(s, "+")
+	table := byName
+	if strings.Contains(mods, "h") {
+		table = hiByName
+	}
+	attr, ok := table[name]
+	if !ok {
+		n, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown color %q", name)
+		}
+		attr = byCode[n%len(byCode)]
+	}
+	attrs := []color.Attribute{attr}
+	if strings.Contains(mods, "b") {
+		attrs = append(attrs, color.Bold)
+	}
+	if strings.Contains(mods, "u") {
+		attrs = append(attrs, color.Underline)
+	}
+	return attrs, nil
+}
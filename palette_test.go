@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestParseTasksEnvAssignmentNotMistakenForName(t *testing.T) {
+	cases := []struct {
+		arg         string
+		wantNamed   bool
+		name        string
+		wantCommand string
+	}{
+		// Inline env assignments must stay unnamed, using the original
+		// numeric prefix, since the var name is conventionally upper-cased.
+		{arg: `PORT=3000 ./serve`, wantCommand: `PORT=3000 ./serve`},
+		{arg: `FOO=bar echo "env is $FOO"`, wantCommand: `FOO=bar echo "env is $FOO"`},
+		// Lowercase-leading labels are still recognized as task names.
+		{arg: `web=./serve`, wantNamed: true, name: "web", wantCommand: "./serve"},
+		{arg: `db.proxy=./dbproxy`, wantNamed: true, name: "db.proxy", wantCommand: "./dbproxy"},
+	}
+	for _, c := range cases {
+		wantName := "0"
+		if c.wantNamed {
+			wantName = c.name
+		}
+		tasks := parseTasks([]string{c.arg})
+		if got := tasks[0]; got.Name != wantName || got.Command != c.wantCommand {
+			t.Errorf("parseTasks(%q) = %+v, want {Name:%q Command:%q}", c.arg, got, wantName, c.wantCommand)
+		}
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	palette, err := parsePalette("red+b,green,208+b,white+b:red")
+	if err != nil {
+		t.Fatalf("parsePalette: %v", err)
+	}
+	if len(palette) != 4 {
+		t.Fatalf("len(palette) = %d, want 4", len(palette))
+	}
+
+	if _, err := parsePalette("not-a-color"); err == nil {
+		t.Error(`parsePalette("not-a-color") = nil error, want error`)
+	}
+}
+
+func TestParseColorAttr(t *testing.T) {
+	attrs, err := parseColorAttr("red+bh", fgColors, fgHiColors, basicFgByCode)
+	if err != nil {
+		t.Fatalf("parseColorAttr: %v", err)
+	}
+	want := []color.Attribute{color.FgHiRed, color.Bold}
+	if len(attrs) != len(want) || attrs[0] != want[0] || attrs[1] != want[1] {
+		t.Errorf("parseColorAttr(%q) = %v, want %v", "red+bh", attrs, want)
+	}
+
+	if _, err := parseColorAttr("bogus", fgColors, fgHiColors, basicFgByCode); err == nil {
+		t.Error(`parseColorAttr("bogus", ...) = nil error, want error`)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportTailLines is how many trailing stdout/stderr lines a reporter
+// keeps per task, to include in its "end" event.
+const reportTailLines = 20
+
+// reportHTTPTimeout bounds how long a single HTTP report POST may take, so
+// an unresponsive -report target cannot hang the reporter indefinitely.
+const reportHTTPTimeout = 10 * time.Second
+
+// reportQueueSize is how many pending report events newReporter buffers
+// before start/line/end start dropping events rather than blocking the
+// task that produced them.
+const reportQueueSize = 1024
+
+// reportEvent is one JSON object sent to a reporter's target, describing a
+// task's start, a line of its output, or its completion.
+type reportEvent struct {
+	Event      string    `json:"event"`
+	Task       string    `json:"task"`
+	Cmd        string    `json:"cmd,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	Time       time.Time `json:"time"`
+	Stream     string    `json:"stream,omitempty"`
+	Line       string    `json:"line,omitempty"`
+	ExitCode   int       `json:"exitcode"`
+	Duration   float64   `json:"duration,omitempty"`
+	StdoutTail []string  `json:"stdout_tail,omitempty"`
+	StderrTail []string  `json:"stderr_tail,omitempty"`
+}
+
+// reporter sends reportEvents to target as tasks start, optionally as
+// they produce output, and as they exit. target is a URL to POST JSON to,
+// or a local command to pipe JSON to if it begins with "|", in which case
+// the command is started once and kept running for the life of the
+// reporter. A nil *reporter is valid and its methods are no-ops, so call
+// sites don't need to special-case -report being unset.
+//
+// start/line/end only enqueue events onto a buffered channel drained by a
+// single background worker goroutine (run); they never block on network
+// I/O themselves, so a stalled -report target cannot stall the tasks it
+// watches or the shutdown path that cancels them.
+type reporter struct {
+	ctx         context.Context
+	target      string
+	reportLines bool
+	client      *http.Client
+
+	events chan reportEvent
+	done   chan struct{}
+
+	mu      sync.Mutex
+	tails   map[string]*taskTail
+	pipeCmd *exec.Cmd
+	pipeIn  io.WriteCloser
+}
+
+type taskTail struct {
+	stdout []string
+	stderr []string
+}
+
+// newReporter starts a reporter whose HTTP/pipe delivery is canceled when
+// ctx is done, e.g. on SIGINT/SIGTERM.
+func newReporter(ctx context.Context, target string, reportLines bool) *reporter {
+	r := &reporter{
+		ctx:         ctx,
+		target:      target,
+		reportLines: reportLines,
+		client:      &http.Client{Timeout: reportHTTPTimeout},
+		events:      make(chan reportEvent, reportQueueSize),
+		done:        make(chan struct{}),
+		tails:       map[string]*taskTail{},
+	}
+	go r.run()
+	return r
+}
+
+func (r *reporter) start(task, cmd string, pid int) {
+	if r == nil {
+		return
+	}
+	r.send(reportEvent{Event: "start", Task: task, Cmd: cmd, PID: pid, Time: time.Now()})
+}
+
+// line records line to task's tail and, if -report-lines was given, sends
+// a "line" event for it.
+func (r *reporter) line(task, stream string, line []byte) {
+	if r == nil {
+		return
+	}
+	r.appendTail(task, stream, string(line))
+	if r.reportLines {
+		r.send(reportEvent{Event: "line", Task: task, Stream: stream, Line: string(line), Time: time.Now()})
+	}
+}
+
+func (r *reporter) end(task string, exitCode int, duration time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	tail := r.tails[task]
+	delete(r.tails, task)
+	r.mu.Unlock()
+	var stdoutTail, stderrTail []string
+	if tail != nil {
+		stdoutTail, stderrTail = tail.stdout, tail.stderr
+	}
+	r.send(reportEvent{
+		Event: "end", Task: task, ExitCode: exitCode, Duration: duration.Seconds(),
+		StdoutTail: stdoutTail, StderrTail: stderrTail, Time: time.Now(),
+	})
+}
+
+// close stops accepting new events, waits for run to drain the remaining
+// queue, and waits for a piped report command to finish reading, if one
+// was started. It is a no-op for a nil *reporter.
+func (r *reporter) close() {
+	if r == nil {
+		return
+	}
+	close(r.events)
+	<-r.done
+}
+
+func (r *reporter) appendTail(task, stream, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tail := r.tails[task]
+	if tail == nil {
+		tail = &taskTail{}
+		r.tails[task] = tail
+	}
+	switch stream {
+	case "stdout":
+		tail.stdout = appendTailLine(tail.stdout, line)
+	case "stderr":
+		tail.stderr = appendTailLine(tail.stderr, line)
+	}
+}
+
+func appendTailLine(tail []string, line string) []string {
+	tail = append(tail, line)
+	if len(tail) > reportTailLines {
+		tail = tail[len(tail)-reportTailLines:]
+	}
+	return tail
+}
+
+// send enqueues ev for delivery by run without blocking the caller. If the
+// queue is full, e.g. because -report-lines is generating events faster
+// than a slow target can absorb them, the event is dropped and logged
+// rather than stalling the task that produced it.
+func (r *reporter) send(ev reportEvent) {
+	select {
+	case r.events <- ev:
+	default:
+		log.Printf("report: queue full, dropping %s event for task %s", ev.Event, ev.Task)
+	}
+}
+
+// run drains r.events, delivering each to r.target, until the channel is
+// closed, then shuts down a piped report command if one was started. It
+// is the only goroutine that performs report I/O, so no per-event
+// synchronization is needed there.
+func (r *reporter) run() {
+	defer close(r.done)
+	for ev := range r.events {
+		r.doSend(ev)
+	}
+	if r.pipeIn != nil {
+		r.pipeIn.Close()
+		if err := r.pipeCmd.Wait(); err != nil {
+			log.Printf("report: %v", err)
+		}
+	}
+}
+
+// doSend delivers ev to r.target, logging but otherwise ignoring
+// failures: a broken report destination should not take down the tasks
+// it watches. HTTP delivery is bounded by reportHTTPTimeout and canceled
+// along with r.ctx, so an unresponsive target cannot hang indefinitely.
+func (r *reporter) doSend(ev reportEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("report: %v", err)
+		return
+	}
+	if cmd, ok := strings.CutPrefix(r.target, "|"); ok {
+		r.sendPipe(cmd, data)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, r.target, bytes.NewReader(data))
+	if err != nil {
+		log.Printf("report: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		log.Printf("report: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// sendPipe writes data, followed by a newline, to cmdStr's stdin,
+// starting cmdStr the first time it is called and reusing the same
+// process for the life of the reporter rather than spawning one per
+// event. It is only ever called from run, so it needs no locking of its
+// own despite mutating r.pipeCmd/r.pipeIn.
+func (r *reporter) sendPipe(cmdStr string, data []byte) {
+	if r.pipeIn == nil {
+		c := exec.Command(shellCommand()[0], shellCommand()[1], cmdStr)
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		stdin, err := c.StdinPipe()
+		if err != nil {
+			log.Printf("report: %v", err)
+			return
+		}
+		if err := c.Start(); err != nil {
+			log.Printf("report: %v", err)
+			return
+		}
+		r.pipeCmd, r.pipeIn = c, stdin
+	}
+	if _, err := r.pipeIn.Write(append(data, '\n')); err != nil {
+		log.Printf("report: %v", err)
+	}
+}
+
+// reportTap is a lineSplitter that calls onLine once per complete raw
+// line before forwarding that line on to next, unmodified. It wraps a
+// task's raw stdout/stderr before it reaches the task's colorizer or
+// jsonFormatter, so a reporter observes the command's actual output
+// rather than its colored or NDJSON-framed rendering.
+type reportTap struct {
+	*lineSplitter
+}
+
+func newReportTap(next io.Writer, onLine func(line []byte)) *reportTap {
+	return &reportTap{lineSplitter: &lineSplitter{
+		emit: func(line []byte) error {
+			onLine(line)
+			_, err := next.Write(append(append([]byte{}, line...), '\n'))
+			return err
+		},
+	}}
+}
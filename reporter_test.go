@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReporterAppendTailIsARingBuffer(t *testing.T) {
+	r := &reporter{tails: map[string]*taskTail{}}
+	for i := 0; i < reportTailLines+5; i++ {
+		r.appendTail("t", "stdout", strconv.Itoa(i))
+	}
+	tail := r.tails["t"].stdout
+	if len(tail) != reportTailLines {
+		t.Fatalf("len(tail) = %d, want %d", len(tail), reportTailLines)
+	}
+	if first, last := tail[0], tail[len(tail)-1]; first != "5" || last != strconv.Itoa(reportTailLines+4) {
+		t.Errorf("tail = %q..%q, want %q..%q (oldest lines evicted)", first, last, "5", strconv.Itoa(reportTailLines+4))
+	}
+}
+
+// TestReporterSendDropsWhenQueueFull ensures send never blocks the task
+// goroutine that called it: once the event queue is full, further events
+// are dropped rather than waiting for a worker to drain it.
+func TestReporterSendDropsWhenQueueFull(t *testing.T) {
+	r := &reporter{events: make(chan reportEvent, 1)}
+	r.send(reportEvent{Event: "line", Task: "0"})
+
+	done := make(chan struct{})
+	go func() {
+		r.send(reportEvent{Event: "line", Task: "0"}) // must not block
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("send blocked with a full queue instead of dropping the event")
+	}
+	if len(r.events) != 1 {
+		t.Errorf("len(r.events) = %d, want 1 (second send should have been dropped)", len(r.events))
+	}
+}